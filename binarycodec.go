@@ -0,0 +1,346 @@
+package gohistogram
+
+// Copyright (c) 2013 VividCortex, Inc. All rights reserved.
+// Please see the LICENSE file for applicable license terms.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// binaryMagic identifies the framed binary format used by MarshalBinary
+// (and its base64 form) and by the BinaryWriter/BinaryReader pair.
+var binaryMagic = [4]byte{'G', 'H', 'W', '1'}
+
+const binaryVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the
+// histogram as magic bytes, a version byte, a maxbins/alpha/total header
+// and a varint-length array of (value, count) bin pairs. This is
+// considerably cheaper to produce and parse than MarshalJSON for
+// frequent snapshotting.
+func (h *WeightedHistogram) MarshalBinary() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var buf bytes.Buffer
+	buf.Write(binaryMagic[:])
+	buf.WriteByte(binaryVersion)
+	writeVarint(&buf, int64(h.maxbins))
+	writeFloat64(&buf, h.alpha)
+	writeVarint(&buf, h.total)
+
+	nodes := h.list.All()
+	writeUvarint(&buf, uint64(len(nodes)))
+	for _, n := range nodes {
+		writeFloat64(&buf, n.value)
+		writeFloat64(&buf, n.count)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for the format
+// produced by MarshalBinary.
+func (h *WeightedHistogram) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return fmt.Errorf("gohistogram: reading magic: %w", err)
+	}
+	if magic != binaryMagic {
+		return fmt.Errorf("gohistogram: unrecognized magic bytes %v", magic)
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("gohistogram: reading version: %w", err)
+	}
+	if version != binaryVersion {
+		return fmt.Errorf("gohistogram: unsupported version %d", version)
+	}
+
+	maxbins, err := binary.ReadVarint(r)
+	if err != nil {
+		return fmt.Errorf("gohistogram: reading maxbins: %w", err)
+	}
+	alpha, err := readFloat64(r)
+	if err != nil {
+		return fmt.Errorf("gohistogram: reading alpha: %w", err)
+	}
+	total, err := binary.ReadVarint(r)
+	if err != nil {
+		return fmt.Errorf("gohistogram: reading total: %w", err)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("gohistogram: reading bin count: %w", err)
+	}
+
+	list := newBinList()
+	for i := uint64(0); i < n; i++ {
+		value, err := readFloat64(r)
+		if err != nil {
+			return fmt.Errorf("gohistogram: reading bin value: %w", err)
+		}
+		count, err := readFloat64(r)
+		if err != nil {
+			return fmt.Errorf("gohistogram: reading bin count: %w", err)
+		}
+		list.Insert(value, count)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxbins = int(maxbins)
+	h.alpha = alpha
+	h.total = total
+	h.list = list
+	h.rebuildGaps()
+
+	return nil
+}
+
+// MarshalBinaryBase64 encodes the histogram with MarshalBinary and returns
+// the result as a single base64 token, suitable for embedding in logs or
+// an HTTP header, as circonusllhist's string form does.
+func (h *WeightedHistogram) MarshalBinaryBase64() (string, error) {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// UnmarshalWeightedHistogramBase64 decodes a histogram previously produced
+// by MarshalBinaryBase64.
+func UnmarshalWeightedHistogramBase64(s string) (*WeightedHistogram, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: decoding base64: %w", err)
+	}
+	h := &WeightedHistogram{}
+	if err := h.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeFloat64(buf *bytes.Buffer, v float64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	buf.Write(tmp[:])
+}
+
+func readFloat64(r io.Reader) (float64, error) {
+	var tmp [8]byte
+	if _, err := io.ReadFull(r, tmp[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(tmp[:])), nil
+}
+
+// BinaryWriter writes a stream of WeightedHistogram snapshots to an
+// underlying io.Writer. The first WriteSnapshot call emits the full
+// header and bin set; subsequent calls emit only the bins that were
+// added, changed or removed since the previous call, which keeps
+// bandwidth low for frequent low-cardinality telemetry snapshots.
+type BinaryWriter struct {
+	w        io.Writer
+	last     map[float64]float64
+	sentHead bool
+}
+
+// NewBinaryWriter returns a BinaryWriter that writes snapshot frames to w.
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{w: w, last: make(map[float64]float64)}
+}
+
+// WriteSnapshot writes the next delta frame for h.
+func (bw *BinaryWriter) WriteSnapshot(h *WeightedHistogram) error {
+	h.mu.RLock()
+	nodes := h.list.All()
+	current := make(map[float64]float64, len(nodes))
+	for _, n := range nodes {
+		current[n.value] = n.count
+	}
+	total := h.total
+	maxbins := h.maxbins
+	alpha := h.alpha
+	h.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if !bw.sentHead {
+		buf.Write(binaryMagic[:])
+		buf.WriteByte(binaryVersion)
+		writeVarint(&buf, int64(maxbins))
+		writeFloat64(&buf, alpha)
+		bw.sentHead = true
+	}
+	writeVarint(&buf, total)
+
+	var changed, removed []float64
+	for v, c := range current {
+		if oc, ok := bw.last[v]; !ok || oc != c {
+			changed = append(changed, v)
+		}
+	}
+	for v := range bw.last {
+		if _, ok := current[v]; !ok {
+			removed = append(removed, v)
+		}
+	}
+	sort.Float64s(changed)
+	sort.Float64s(removed)
+
+	writeUvarint(&buf, uint64(len(changed)))
+	for _, v := range changed {
+		writeFloat64(&buf, v)
+		writeFloat64(&buf, current[v])
+	}
+	writeUvarint(&buf, uint64(len(removed)))
+	for _, v := range removed {
+		writeFloat64(&buf, v)
+	}
+
+	bw.last = current
+	_, err := bw.w.Write(buf.Bytes())
+	return err
+}
+
+// BinaryReader reconstructs a WeightedHistogram from the delta frames
+// written by a BinaryWriter.
+type BinaryReader struct {
+	r       io.Reader
+	h       *WeightedHistogram
+	gotHead bool
+	state   map[float64]float64
+}
+
+// NewBinaryReader returns a BinaryReader reading frames from r.
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: r, state: make(map[float64]float64)}
+}
+
+// ReadSnapshot reads and applies the next delta frame, returning the
+// histogram's state after applying it. The returned histogram is reused
+// across calls; callers that need to retain a given snapshot should copy
+// it (e.g. via MarshalBinary) before calling ReadSnapshot again.
+func (br *BinaryReader) ReadSnapshot() (*WeightedHistogram, error) {
+	if !br.gotHead {
+		var magic [4]byte
+		if _, err := io.ReadFull(br.r, magic[:]); err != nil {
+			return nil, fmt.Errorf("gohistogram: reading magic: %w", err)
+		}
+		if magic != binaryMagic {
+			return nil, fmt.Errorf("gohistogram: unrecognized magic bytes %v", magic)
+		}
+		var versionBuf [1]byte
+		if _, err := io.ReadFull(br.r, versionBuf[:]); err != nil {
+			return nil, fmt.Errorf("gohistogram: reading version: %w", err)
+		}
+		if versionBuf[0] != binaryVersion {
+			return nil, fmt.Errorf("gohistogram: unsupported version %d", versionBuf[0])
+		}
+		maxbins, err := binary.ReadVarint(toByteReader(br.r))
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading maxbins: %w", err)
+		}
+		alpha, err := readFloat64(br.r)
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading alpha: %w", err)
+		}
+		br.h = &WeightedHistogram{maxbins: int(maxbins), alpha: alpha}
+		br.gotHead = true
+	}
+
+	br_ := toByteReader(br.r)
+	total, err := binary.ReadVarint(br_)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading total: %w", err)
+	}
+
+	numChanged, err := binary.ReadUvarint(br_)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading changed count: %w", err)
+	}
+	for i := uint64(0); i < numChanged; i++ {
+		value, err := readFloat64(br.r)
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading changed value: %w", err)
+		}
+		count, err := readFloat64(br.r)
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading changed count: %w", err)
+		}
+		br.state[value] = count
+	}
+
+	numRemoved, err := binary.ReadUvarint(br_)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading removed count: %w", err)
+	}
+	for i := uint64(0); i < numRemoved; i++ {
+		value, err := readFloat64(br.r)
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading removed value: %w", err)
+		}
+		delete(br.state, value)
+	}
+
+	values := make([]float64, 0, len(br.state))
+	for v := range br.state {
+		values = append(values, v)
+	}
+	sort.Float64s(values)
+
+	list := newBinList()
+	for _, v := range values {
+		list.Insert(v, br.state[v])
+	}
+
+	br.h.mu.Lock()
+	br.h.list = list
+	br.h.rebuildGaps()
+	br.h.total = total
+	br.h.mu.Unlock()
+
+	return br.h, nil
+}
+
+// toByteReader adapts r to io.ByteReader, which the binary varint readers
+// require; bytes.Reader already implements it, byteReaderWrapper covers
+// the general case.
+func toByteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &byteReaderWrapper{r: r}
+}
+
+type byteReaderWrapper struct {
+	r io.Reader
+}
+
+func (w *byteReaderWrapper) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(w.r, b[:])
+	return b[0], err
+}
@@ -0,0 +1,87 @@
+package gohistogram
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestWeightedHistogramMarshalBinary(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
+	for i := 0; i < 100; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary returned error: %v", err)
+	}
+
+	var h2 WeightedHistogram
+	if err := h2.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary returned error: %v", err)
+	}
+
+	if h2.Count() != h.Count() {
+		t.Errorf("Expected round-tripped Count() %v, got %v", h.Count(), h2.Count())
+	}
+	if h2.Quantile(0.5) != h.Quantile(0.5) {
+		t.Errorf("Expected round-tripped Quantile(0.5) %v, got %v", h.Quantile(0.5), h2.Quantile(0.5))
+	}
+}
+
+func TestWeightedHistogramMarshalBinaryBase64(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
+	for i := 0; i < 50; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	s, err := h.MarshalBinaryBase64()
+	if err != nil {
+		t.Fatalf("MarshalBinaryBase64 returned error: %v", err)
+	}
+
+	h2, err := UnmarshalWeightedHistogramBase64(s)
+	if err != nil {
+		t.Fatalf("UnmarshalWeightedHistogramBase64 returned error: %v", err)
+	}
+	if h2.Count() != h.Count() {
+		t.Errorf("Expected round-tripped Count() %v, got %v", h.Count(), h2.Count())
+	}
+}
+
+func TestBinaryWriterReaderDeltas(t *testing.T) {
+	h := NewWeightedHistogram(10, 1)
+	for i := 0; i < 50; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	var buf bytes.Buffer
+	w := NewBinaryWriter(&buf)
+	if err := w.WriteSnapshot(h); err != nil {
+		t.Fatalf("WriteSnapshot returned error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		h.Add(rand.NormFloat64())
+	}
+	if err := w.WriteSnapshot(h); err != nil {
+		t.Fatalf("second WriteSnapshot returned error: %v", err)
+	}
+
+	r := NewBinaryReader(&buf)
+	if _, err := r.ReadSnapshot(); err != nil {
+		t.Fatalf("first ReadSnapshot returned error: %v", err)
+	}
+	s2, err := r.ReadSnapshot()
+	if err != nil {
+		t.Fatalf("second ReadSnapshot returned error: %v", err)
+	}
+
+	if s2.Count() != h.Count() {
+		t.Errorf("Expected streamed Count() %v, got %v", h.Count(), s2.Count())
+	}
+	if s2.BinsCount() != h.BinsCount() {
+		t.Errorf("Expected streamed BinsCount() %v, got %v", h.BinsCount(), s2.BinsCount())
+	}
+}
@@ -0,0 +1,257 @@
+package gohistogram
+
+// Copyright (c) 2013 VividCortex, Inc. All rights reserved.
+// Please see the LICENSE file for applicable license terms.
+
+import (
+	"container/heap"
+	"math/rand"
+)
+
+const binListMaxLevel = 32
+const binListP = 0.25
+
+// binNode is a node in binList, a value-ordered skip list augmented with
+// per-level spans so that Get(i) can locate the i'th node by rank in
+// O(log k) instead of walking the list.
+//
+// gen is bumped every time this node's value changes or its level-0
+// successor changes; gapHeap entries record the gen they were pushed
+// with and are discarded as stale if it no longer matches.
+type binNode struct {
+	value, count float64
+	forward      []*binNode
+	span         []int
+	gen          int
+	deleted      bool
+}
+
+// binList is a skip list of bins ordered by value, used by
+// WeightedHistogram in place of a plain slice so that insertion,
+// deletion and rank-based lookup are all O(log k) rather than O(k).
+type binList struct {
+	head   *binNode
+	level  int
+	length int
+}
+
+func newBinList() *binList {
+	return &binList{
+		head:  &binNode{forward: make([]*binNode, binListMaxLevel), span: make([]int, binListMaxLevel)},
+		level: 1,
+	}
+}
+
+// Len returns the number of nodes in the list. A nil *binList (the zero
+// value of WeightedHistogram before any bin has been inserted) is treated
+// as empty rather than panicking, so read-only WeightedHistogram methods
+// work on a zero-value histogram without needing a write lock to allocate
+// one first.
+func (b *binList) Len() int {
+	if b == nil {
+		return 0
+	}
+	return b.length
+}
+
+func randomLevel() int {
+	level := 1
+	for rand.Float64() < binListP && level < binListMaxLevel {
+		level++
+	}
+	return level
+}
+
+// search returns, for each level, the last node with value < target (or
+// the head sentinel), along with the rank (0-based index of that node,
+// -1 for the head) at each level. This is the standard skip list search
+// used by both Insert and Delete.
+func (b *binList) search(target float64) (update [binListMaxLevel]*binNode, rank [binListMaxLevel]int) {
+	node := b.head
+	r := -1
+	for i := b.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && node.forward[i].value < target {
+			r += node.span[i]
+			node = node.forward[i]
+		}
+		update[i] = node
+		rank[i] = r
+	}
+	return update, rank
+}
+
+// Predecessor returns node's current predecessor in value order, or the
+// head sentinel if node is the first real node. node must be a member of
+// the list and have a value distinct from all others (true of every node
+// WeightedHistogram keeps live, since equal-value bins are merged by
+// incrementing count in place instead of inserting a duplicate).
+func (b *binList) Predecessor(node *binNode) *binNode {
+	update, _ := b.search(node.value)
+	return update[0]
+}
+
+// findExact returns the node with the given value, or nil if absent. A
+// nil *binList is treated as empty, as with Len.
+func (b *binList) findExact(value float64) *binNode {
+	if b == nil {
+		return nil
+	}
+	node := b.head
+	for i := b.level - 1; i >= 0; i-- {
+		for node.forward[i] != nil && node.forward[i].value < value {
+			node = node.forward[i]
+		}
+	}
+	node = node.forward[0]
+	if node != nil && node.value == value {
+		return node
+	}
+	return nil
+}
+
+// Insert adds a new node with the given value and count. The caller must
+// ensure no node with this value already exists (WeightedHistogram.Add
+// checks via findExact first and increments in place instead).
+func (b *binList) Insert(value, count float64) *binNode {
+	update, rank := b.search(value)
+
+	level := randomLevel()
+	if level > b.level {
+		for i := b.level; i < level; i++ {
+			update[i] = b.head
+			rank[i] = -1
+			b.head.span[i] = b.length
+		}
+		b.level = level
+	}
+
+	node := &binNode{
+		value:   value,
+		count:   count,
+		forward: make([]*binNode, level),
+		span:    make([]int, level),
+	}
+
+	for i := 0; i < level; i++ {
+		node.forward[i] = update[i].forward[i]
+		update[i].forward[i] = node
+
+		span := rank[0] - rank[i] + 1
+		node.span[i] = update[i].span[i] - span + 1
+		update[i].span[i] = span
+	}
+	for i := level; i < b.level; i++ {
+		update[i].span[i]++
+	}
+
+	update[0].gen++
+	b.length++
+
+	return node
+}
+
+// Delete removes node from the list. node must currently be a member.
+func (b *binList) Delete(node *binNode) {
+	update, _ := b.search(node.value)
+
+	for i := 0; i < b.level; i++ {
+		if update[i].forward[i] == node {
+			update[i].span[i] += node.span[i] - 1
+			update[i].forward[i] = node.forward[i]
+		} else {
+			update[i].span[i]--
+		}
+	}
+	for b.level > 1 && b.head.forward[b.level-1] == nil {
+		b.level--
+	}
+
+	update[0].gen++
+	node.deleted = true
+	b.length--
+}
+
+// Get returns the i'th node in ascending value order, in O(log k). A nil
+// *binList is treated as empty, as with Len.
+func (b *binList) Get(i int) *binNode {
+	if b == nil || i < 0 || i >= b.length {
+		return nil
+	}
+	node := b.head
+	rank := -1
+	for level := b.level - 1; level >= 0; level-- {
+		for node.forward[level] != nil && rank+node.span[level] <= i {
+			rank += node.span[level]
+			node = node.forward[level]
+		}
+	}
+	return node
+}
+
+// All returns every node in ascending value order. O(k). A nil *binList
+// is treated as empty, as with Len.
+func (b *binList) All() []*binNode {
+	if b == nil {
+		return nil
+	}
+	nodes := make([]*binNode, 0, b.length)
+	for n := b.head.forward[0]; n != nil; n = n.forward[0] {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// gapEntry is a candidate merge point in WeightedHistogram.trim: the gap
+// between left and its current level-0 successor. It is pushed onto
+// gapHeap keyed by delta, and lazily discarded at pop time if left has
+// since been deleted or its successor has changed (tracked via gen).
+type gapEntry struct {
+	left  *binNode
+	gen   int
+	delta float64
+}
+
+// gapHeap is a container/heap min-heap of gapEntry ordered by delta,
+// letting WeightedHistogram.trim find the closest pair of adjacent bins
+// in O(log k) instead of scanning every bin.
+type gapHeap []gapEntry
+
+func (h gapHeap) Len() int            { return len(h) }
+func (h gapHeap) Less(i, j int) bool  { return h[i].delta < h[j].delta }
+func (h gapHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *gapHeap) Push(x interface{}) { *h = append(*h, x.(gapEntry)) }
+func (h *gapHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
+
+// pushGap records the current gap to the right of left, if left has a
+// successor. left must be a real node, not the head sentinel (whose value
+// is meaningless); pushGap does not check this, and callers only pass
+// real nodes.
+func pushGap(h *gapHeap, left *binNode) {
+	right := left.forward[0]
+	if right == nil {
+		return
+	}
+	heap.Push(h, gapEntry{left: left, gen: left.gen, delta: right.value - left.value})
+}
+
+// popValidGap pops and returns the minimal still-valid gap entry, or nil
+// if none remain (fewer than two real bins).
+func popValidGap(h *gapHeap) *gapEntry {
+	for h.Len() > 0 {
+		entry := heap.Pop(h).(gapEntry)
+		if entry.left.deleted || entry.left.gen != entry.gen {
+			continue
+		}
+		if entry.left.forward[0] == nil {
+			continue
+		}
+		return &entry
+	}
+	return nil
+}
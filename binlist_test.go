@@ -0,0 +1,193 @@
+package gohistogram
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestWeightedHistogramTrimInvariants(t *testing.T) {
+	h := NewWeightedHistogram(15, 0.999)
+	for i := 0; i < 2000; i++ {
+		h.Add(rand.NormFloat64() * 10)
+	}
+
+	n := h.BinsCount()
+	if n > 15 {
+		t.Fatalf("Expected BinsCount() <= 15, got %d", n)
+	}
+
+	prev := math.Inf(-1)
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		c, v := h.Bins(i)
+		if v <= prev {
+			t.Fatalf("Expected bins in ascending value order, got %v after %v at index %d", v, prev, i)
+		}
+		prev = v
+		sum += c
+	}
+
+	if int64(sum) != h.Count() {
+		t.Errorf("Expected sum of bin counts %v to equal Count() %v", sum, h.Count())
+	}
+}
+
+// bruteForceTrim mirrors the pre-skip-list trim semantics: repeatedly scan
+// all adjacent pairs for the smallest value gap and merge it. It is used
+// only as a reference oracle to cross-check WeightedHistogram.trim's
+// skip-list/gap-heap result, which must pick the same pair.
+func bruteForceTrim(nodes []histogramStateBin, maxbins int) []histogramStateBin {
+	for len(nodes) > maxbins {
+		minIdx := 0
+		minDelta := nodes[1].Value - nodes[0].Value
+		for i := 1; i+1 < len(nodes); i++ {
+			delta := nodes[i+1].Value - nodes[i].Value
+			if delta < minDelta {
+				minDelta = delta
+				minIdx = i
+			}
+		}
+		left, right := nodes[minIdx], nodes[minIdx+1]
+		totalCount := left.Count + right.Count
+		var newValue float64
+		if totalCount <= 1 {
+			newValue = (left.Value + right.Value) / 2
+		} else {
+			newValue = (left.Value*left.Count + right.Value*right.Count) / totalCount
+		}
+		merged := histogramStateBin{Value: newValue, Count: totalCount}
+		next := append([]histogramStateBin{}, nodes[:minIdx]...)
+		next = append(next, merged)
+		next = append(next, nodes[minIdx+2:]...)
+		nodes = next
+	}
+	return nodes
+}
+
+func insertSortedBin(bins []histogramStateBin, v float64) []histogramStateBin {
+	for i, b := range bins {
+		if b.Value == v {
+			bins[i].Count++
+			return bins
+		}
+	}
+	bins = append(bins, histogramStateBin{Value: v, Count: 1})
+	sort.Slice(bins, func(i, j int) bool { return bins[i].Value < bins[j].Value })
+	return bins
+}
+
+// TestWeightedHistogramTrimMatchesBruteForce checks that trim's gap-heap
+// based merge selection always picks the same pair a full O(k) scan would,
+// across many Add sequences. The gap heap's staleness tracking (gapEntry.gen)
+// has to account for a node's predecessor as well as the node itself: a
+// merge changes the merged node's value, which invalidates any cached gap
+// entry its predecessor is still holding, not just its own.
+func TestWeightedHistogramTrimMatchesBruteForce(t *testing.T) {
+	for seed := 0; seed < 200; seed++ {
+		r := rand.New(rand.NewSource(int64(seed)))
+		h := NewWeightedHistogram(20, 1)
+		var ref []histogramStateBin
+
+		for i := 0; i < 300; i++ {
+			v := r.Float64() * 200
+			h.Add(v)
+			ref = insertSortedBin(ref, v)
+			ref = bruteForceTrim(ref, 20)
+		}
+
+		got := h.list.All()
+		if len(got) != len(ref) {
+			t.Fatalf("seed %d: got %d bins, reference has %d", seed, len(got), len(ref))
+		}
+		for i := range got {
+			if got[i].value != ref[i].Value || got[i].count != ref[i].Count {
+				t.Fatalf("seed %d: bin %d = (%v, %v), reference wants (%v, %v)",
+					seed, i, got[i].value, got[i].count, ref[i].Value, ref[i].Count)
+			}
+		}
+	}
+}
+
+// TestWeightedHistogramGapHeapBounded checks that compactGaps keeps the
+// gap heap from growing without bound under sustained Adds.
+func TestWeightedHistogramGapHeapBounded(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
+	for i := 0; i < 500000; i++ {
+		h.Add(rand.Float64() * 1000)
+		if len(h.gaps) > gapHeapSlack*h.maxbins+16 {
+			t.Fatalf("gap heap grew unbounded: %d entries after %d Adds", len(h.gaps), i+1)
+		}
+	}
+}
+
+func bruteQuantile(nodes []histogramStateBin, total int64, q float64) float64 {
+	count := q * float64(total)
+	for _, n := range nodes {
+		count -= n.Count
+		if count <= 0 {
+			return n.Value
+		}
+	}
+	return -1
+}
+
+func bruteCDF(nodes []histogramStateBin, total int64, x float64) float64 {
+	count := 0.0
+	for _, n := range nodes {
+		if n.Value <= x {
+			count += n.Count
+		}
+	}
+	return count / float64(total)
+}
+
+// TestQuantileCDFMatchesBruteForce checks that Quantile/CDF, now answered
+// from the lazily rebuilt qIndex, return exactly what a full O(k) scan
+// over the current bins would.
+func TestQuantileCDFMatchesBruteForce(t *testing.T) {
+	for seed := 0; seed < 50; seed++ {
+		r := rand.New(rand.NewSource(int64(seed)))
+		h := NewWeightedHistogram(20, 0.99)
+		for i := 0; i < 500; i++ {
+			h.Add(r.NormFloat64() * 10)
+
+			nodes := h.list.All()
+			bins := make([]histogramStateBin, len(nodes))
+			for j, n := range nodes {
+				bins[j] = histogramStateBin{Value: n.value, Count: n.count}
+			}
+
+			for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1.0} {
+				want := bruteQuantile(bins, h.total, q)
+				got := h.Quantile(q)
+				if want != got {
+					t.Fatalf("seed %d iter %d: Quantile(%v) = %v, want %v", seed, i, q, got, want)
+				}
+			}
+			for _, x := range []float64{-30, -5, 0, 5, 30} {
+				want := bruteCDF(bins, h.total, x)
+				got := h.CDF(x)
+				if want != got && !(want != want && got != got) {
+					t.Fatalf("seed %d iter %d: CDF(%v) = %v, want %v", seed, i, x, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBinListGetMatchesAll(t *testing.T) {
+	h := NewWeightedHistogram(30, 1)
+	for i := 0; i < 500; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	nodes := h.list.All()
+	for i, n := range nodes {
+		c, v := h.Bins(i)
+		if v != n.value || c != n.count {
+			t.Errorf("Bins(%d) = (%v, %v), want (%v, %v)", i, c, v, n.count, n.value)
+		}
+	}
+}
@@ -3,17 +3,18 @@ package gohistogram
 import (
 	"math"
 	"math/rand"
+	"sync"
 	"testing"
 )
 
-func TestHistogram(t *testing.T) {
-	h := NewHistogram(20)
+func TestWeightedHistogram(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
 	for i := 0; i < 100; i++ {
 		h.Add(rand.NormFloat64())
 	}
 
 	if h.total != 100 {
-		t.Errorf("Expected h.total to be 100, got ", h.total)
+		t.Errorf("Expected h.total to be 100, got %v", h.total)
 	}
 
 	if per := h.Quantile(0.5); math.Abs(per) > 0.13 {
@@ -22,28 +23,96 @@ func TestHistogram(t *testing.T) {
 	if per := h.Quantile(0.75); math.Abs(per-0.675) > 0.13 {
 		t.Errorf("Expected 75th percentile to be 0.675, got %v", per)
 	}
-	if per := h.Quantile(0.9); math.Abs(per-1.282) > 0.13 {
+	if per := h.Quantile(0.9); math.Abs(per-1.282) > 0.26 {
 		t.Errorf("Expected 90th percentile to be 1.282, got %v", per)
 	}
 }
 
-func TestWeightedHistogram(t *testing.T) {
+func TestWeightedHistogramConcurrent(t *testing.T) {
 	h := NewWeightedHistogram(20, 1)
-	for i := 0; i < 100; i++ {
+
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				h.Add(rand.NormFloat64())
+				h.Quantile(0.5)
+				h.Mean()
+				h.Variance()
+				h.Modes(3)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.Count() != 1000 {
+		t.Errorf("Expected h.Count() to be 1000, got %v", h.Count())
+	}
+}
+
+func TestWeightedHistogramMerge(t *testing.T) {
+	a := NewWeightedHistogram(20, 1)
+	b := NewWeightedHistogram(20, 1)
+	for i := 0; i < 50; i++ {
+		a.Add(rand.NormFloat64())
+		b.Add(rand.NormFloat64())
+	}
+
+	a.Merge(b)
+
+	if a.Count() != 100 {
+		t.Errorf("Expected a.Count() to be 100 after merge, got %v", a.Count())
+	}
+	if b.Count() != 50 {
+		t.Errorf("Expected b.Count() to be unchanged at 50, got %v", b.Count())
+	}
+}
+
+func TestWeightedHistogramClear(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
+	for i := 0; i < 50; i++ {
 		h.Add(rand.NormFloat64())
 	}
 
-	if h.total != 100 {
-		t.Errorf("Expected h.total to be 100, got ", h.total)
+	h.Clear()
+
+	if h.Count() != 0 {
+		t.Errorf("Expected h.Count() to be 0 after Clear, got %v", h.Count())
+	}
+	if h.BinsCount() != 0 {
+		t.Errorf("Expected h.BinsCount() to be 0 after Clear, got %v", h.BinsCount())
 	}
 
-	if per := h.Quantile(0.5); math.Abs(per) > 0.13 {
-		t.Errorf("Expected 50th percentile to be 0.0, got %v", per)
+	h.Add(1)
+	if h.Count() != 1 {
+		t.Errorf("Expected h.Count() to be 1 after Add following Clear, got %v", h.Count())
 	}
-	if per := h.Quantile(0.75); math.Abs(per-0.675) > 0.13 {
-		t.Errorf("Expected 75th percentile to be 0.675, got %v", per)
+}
+
+func TestWeightedHistogramZeroValue(t *testing.T) {
+	var h WeightedHistogram
+
+	if n := h.BinsCount(); n != 0 {
+		t.Errorf("Expected zero value BinsCount() to be 0, got %v", n)
 	}
-	if per := h.Quantile(0.9); math.Abs(per-1.282) > 0.26 {
-		t.Errorf("Expected 90th percentile to be 1.282, got %v", per)
+	if c := h.Count(); c != 0 {
+		t.Errorf("Expected zero value Count() to be 0, got %v", c)
 	}
-}
\ No newline at end of file
+
+	// alpha is 0 on the zero value, which decays every other bin to 0 on
+	// each Add; set it so this test isolates list/gaps lazy init from
+	// that unrelated EWMA behavior. maxbins is still 0, so Add trims
+	// down to a single bin rather than panicking.
+	h.alpha = 1
+
+	h.Add(1)
+	h.Add(2)
+	if h.Count() != 2 {
+		t.Errorf("Expected h.Count() to be 2 after Add on a zero value, got %v", h.Count())
+	}
+	if n := h.BinsCount(); n != 1 {
+		t.Errorf("Expected zero value BinsCount() to trim to 1 bin, got %v", n)
+	}
+}
@@ -0,0 +1,487 @@
+package gohistogram
+
+// Copyright (c) 2013 VividCortex, Inc. All rights reserved.
+// Please see the LICENSE file for applicable license terms.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// subBucketsPerDecade is the number of sub-buckets each decade (power of
+// ten) is split into. Each sub-bucket spans a tenth of the decade's base
+// power of ten, which is between 1% and 10% of the values it holds.
+const subBucketsPerDecade = 90
+
+// minExponent and maxExponent bound the decade range a LogLinearHistogram
+// can represent; values outside 10^minExponent..10^maxExponent collapse
+// into the first or last decade.
+const (
+	minExponent = -128
+	maxExponent = 127
+	numDecades  = maxExponent - minExponent + 1
+)
+
+// A LogLinearHistogram implements Histogram using fixed log-linear
+// (HDR/Circonus-style) buckets instead of the adaptive bins of
+// WeightedHistogram. Every positive value v is decomposed as
+// v = m * 10^e with 1 <= m < 10, and its bucket is (e, floor(m*10)),
+// giving subBucketsPerDecade buckets per decade. This trades the
+// streaming histogram's unbounded, recency-weighted bins for O(1)
+// inserts and a bounded relative error (each bucket is 1% to 10% as wide
+// as the values in it), and unlike WeightedHistogram,
+// its Merge is exact (bucket counts simply add).
+//
+// The zero value is not ready to use; call NewLogLinearHistogram.
+type LogLinearHistogram struct {
+	mu sync.RWMutex
+
+	// buckets[sign][exponent-minExponent][subBucket] holds the count for
+	// that bucket. sign 0 is the positive side, sign 1 the negative side.
+	buckets [2][numDecades][subBucketsPerDecade]uint64
+	zeros   uint64
+
+	total int64
+	min   float64
+	max   float64
+}
+
+// NewLogLinearHistogram returns an empty LogLinearHistogram.
+func NewLogLinearHistogram() *LogLinearHistogram {
+	return &LogLinearHistogram{
+		min: math.Inf(1),
+		max: math.Inf(-1),
+	}
+}
+
+// decompose returns the sign (0 for positive, 1 for negative), decade
+// index and sub-bucket index for v. v must be non-zero.
+func decompose(v float64) (sign int, decade int, sub int) {
+	sign = 0
+	av := v
+	if v < 0 {
+		sign = 1
+		av = -v
+	}
+
+	e := int(math.Floor(math.Log10(av)))
+	m := av / math.Pow(10, float64(e))
+
+	// Guard against floating point error pushing m just outside [1, 10).
+	if m < 1 {
+		m = 1
+		e--
+	} else if m >= 10 {
+		m = 1
+		e++
+	}
+
+	if e < minExponent {
+		e = minExponent
+		m = 1
+	} else if e > maxExponent {
+		e = maxExponent
+		m = 9.999999999
+	}
+
+	// floor(m*10) runs from 10 to 99; shift it to a 0-based index.
+	s := int(m*10) - 10
+	if s >= subBucketsPerDecade {
+		s = subBucketsPerDecade - 1
+	}
+
+	return sign, e - minExponent, s
+}
+
+// bucketBounds returns the lower and upper edge of the bucket identified
+// by sign, decade and sub.
+func bucketBounds(sign, decade, sub int) (lower, upper float64) {
+	e := decade + minExponent
+	scale := math.Pow(10, float64(e))
+	lower = float64(sub+10) / 10 * scale
+	upper = float64(sub+11) / 10 * scale
+	if sign == 1 {
+		lower, upper = -upper, -lower
+	}
+	return lower, upper
+}
+
+// Add adds value to the histogram.
+func (h *LogLinearHistogram) Add(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.total++
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+
+	if v == 0 {
+		h.zeros++
+		return
+	}
+
+	sign, decade, sub := decompose(v)
+	h.buckets[sign][decade][sub]++
+}
+
+// Quantile implements Histogram.Quantile and returns an approximation,
+// interpolating linearly within the containing bucket.
+func (h *LogLinearHistogram) Quantile(q float64) float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.total == 0 {
+		return 0
+	}
+
+	target := q * float64(h.total)
+	count := 0.0
+
+	// Negative side, from the most negative decade down to -0.
+	for decade := numDecades - 1; decade >= 0; decade-- {
+		for sub := subBucketsPerDecade - 1; sub >= 0; sub-- {
+			c := float64(h.buckets[1][decade][sub])
+			if c == 0 {
+				continue
+			}
+			count += c
+			if count >= target {
+				lower, upper := bucketBounds(1, decade, sub)
+				return interpolate(lower, upper, c, count-target)
+			}
+		}
+	}
+
+	count += float64(h.zeros)
+	if count >= target {
+		return 0
+	}
+
+	for decade := 0; decade < numDecades; decade++ {
+		for sub := 0; sub < subBucketsPerDecade; sub++ {
+			c := float64(h.buckets[0][decade][sub])
+			if c == 0 {
+				continue
+			}
+			count += c
+			if count >= target {
+				lower, upper := bucketBounds(0, decade, sub)
+				return interpolate(lower, upper, c, count-target)
+			}
+		}
+	}
+
+	return h.max
+}
+
+// interpolate returns a value within [lower, upper] assuming the bucket's
+// c observations are spread evenly across it, where remaining is the part
+// of c that lies past the target rank.
+func interpolate(lower, upper, c, remaining float64) float64 {
+	frac := (c - remaining) / c
+	return lower + frac*(upper-lower)
+}
+
+// CDF returns the value of the cumulative distribution function at x.
+func (h *LogLinearHistogram) CDF(x float64) float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.total == 0 {
+		return 0
+	}
+
+	count := 0.0
+	for decade := numDecades - 1; decade >= 0; decade-- {
+		for sub := subBucketsPerDecade - 1; sub >= 0; sub-- {
+			_, upper := bucketBounds(1, decade, sub)
+			if upper <= x {
+				count += float64(h.buckets[1][decade][sub])
+			}
+		}
+	}
+
+	if x >= 0 {
+		count += float64(h.zeros)
+	}
+
+	for decade := 0; decade < numDecades; decade++ {
+		for sub := 0; sub < subBucketsPerDecade; sub++ {
+			_, upper := bucketBounds(0, decade, sub)
+			if upper <= x {
+				count += float64(h.buckets[0][decade][sub])
+			}
+		}
+	}
+
+	return count / float64(h.total)
+}
+
+// Mean returns the sample mean of the distribution, approximating each
+// bucket's contribution by its midpoint.
+func (h *LogLinearHistogram) Mean() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.total == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for sign := 0; sign < 2; sign++ {
+		for decade := 0; decade < numDecades; decade++ {
+			for sub := 0; sub < subBucketsPerDecade; sub++ {
+				c := h.buckets[sign][decade][sub]
+				if c == 0 {
+					continue
+				}
+				lower, upper := bucketBounds(sign, decade, sub)
+				sum += (lower + upper) / 2 * float64(c)
+			}
+		}
+	}
+
+	return sum / float64(h.total)
+}
+
+// Min returns the smallest value added to the histogram.
+func (h *LogLinearHistogram) Min() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest value added to the histogram.
+func (h *LogLinearHistogram) Max() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.total == 0 {
+		return 0
+	}
+	return h.max
+}
+
+// Count returns the number of values added to the histogram.
+func (h *LogLinearHistogram) Count() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.total
+}
+
+// Merge adds other's bucket counts into h. Unlike WeightedHistogram.Merge,
+// this is exact and commutative: fixed buckets never need to be trimmed,
+// so no precision is lost regardless of merge order.
+func (h *LogLinearHistogram) Merge(other *LogLinearHistogram) {
+	other.mu.RLock()
+	otherBuckets := other.buckets
+	otherZeros := other.zeros
+	otherTotal := other.total
+	otherMin := other.min
+	otherMax := other.max
+	other.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sign := 0; sign < 2; sign++ {
+		for decade := 0; decade < numDecades; decade++ {
+			for sub := 0; sub < subBucketsPerDecade; sub++ {
+				h.buckets[sign][decade][sub] += otherBuckets[sign][decade][sub]
+			}
+		}
+	}
+	h.zeros += otherZeros
+	h.total += otherTotal
+	if otherMin < h.min {
+		h.min = otherMin
+	}
+	if otherMax > h.max {
+		h.max = otherMax
+	}
+}
+
+// String returns a string representation of the histogram, which is
+// useful for printing to a terminal.
+func (h *LogLinearHistogram) String() (str string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return fmt.Sprintln("Total:", h.total)
+}
+
+// BinsCount implements Histogram and returns the number of non-empty
+// buckets, including the zero bucket if it has been hit.
+func (h *LogLinearHistogram) BinsCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	n := 0
+	if h.zeros > 0 {
+		n++
+	}
+	for sign := 0; sign < 2; sign++ {
+		for decade := 0; decade < numDecades; decade++ {
+			for sub := 0; sub < subBucketsPerDecade; sub++ {
+				if h.buckets[sign][decade][sub] > 0 {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// Bins implements Histogram, returning the count and representative
+// (midpoint) value of the i'th non-empty bucket, in ascending value order.
+func (h *LogLinearHistogram) Bins(i int) (float64, float64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if i < 0 {
+		return 0, 0
+	}
+
+	idx := 0
+	for decade := numDecades - 1; decade >= 0; decade-- {
+		for sub := subBucketsPerDecade - 1; sub >= 0; sub-- {
+			c := h.buckets[1][decade][sub]
+			if c == 0 {
+				continue
+			}
+			if idx == i {
+				lower, upper := bucketBounds(1, decade, sub)
+				return float64(c), (lower + upper) / 2
+			}
+			idx++
+		}
+	}
+
+	if h.zeros > 0 {
+		if idx == i {
+			return float64(h.zeros), 0
+		}
+		idx++
+	}
+
+	for decade := 0; decade < numDecades; decade++ {
+		for sub := 0; sub < subBucketsPerDecade; sub++ {
+			c := h.buckets[0][decade][sub]
+			if c == 0 {
+				continue
+			}
+			if idx == i {
+				lower, upper := bucketBounds(0, decade, sub)
+				return float64(c), (lower + upper) / 2
+			}
+			idx++
+		}
+	}
+
+	return 0, 0
+}
+
+// Serialize encodes the histogram into a compact binary form: the total,
+// the zero bucket count, min and max as varints, then for each non-zero
+// bucket a uvarint key packing (sign, decade, sub) followed by a uvarint
+// count. It is intended for periodic snapshotting
+// where many buckets are empty and JSON would be wasteful.
+func (h *LogLinearHistogram) Serialize() []byte {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(tmp[:], v)
+		buf.Write(tmp[:n])
+	}
+	putVarint := func(v int64) {
+		n := binary.PutVarint(tmp[:], v)
+		buf.Write(tmp[:n])
+	}
+
+	putVarint(h.total)
+	putUvarint(h.zeros)
+	putVarint(int64(math.Float64bits(h.min)))
+	putVarint(int64(math.Float64bits(h.max)))
+
+	for sign := 0; sign < 2; sign++ {
+		for decade := 0; decade < numDecades; decade++ {
+			for sub := 0; sub < subBucketsPerDecade; sub++ {
+				c := h.buckets[sign][decade][sub]
+				if c == 0 {
+					continue
+				}
+				putUvarint(uint64(sign*numDecades*subBucketsPerDecade + decade*subBucketsPerDecade + sub))
+				putUvarint(c)
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// DeserializeLogLinearHistogram decodes a histogram previously produced by
+// Serialize.
+func DeserializeLogLinearHistogram(data []byte) (*LogLinearHistogram, error) {
+	h := NewLogLinearHistogram()
+	r := bytes.NewReader(data)
+
+	total, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading total: %w", err)
+	}
+	zeros, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading zeros: %w", err)
+	}
+	minBits, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading min: %w", err)
+	}
+	maxBits, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("gohistogram: reading max: %w", err)
+	}
+
+	h.total = total
+	h.zeros = zeros
+	h.min = math.Float64frombits(uint64(minBits))
+	h.max = math.Float64frombits(uint64(maxBits))
+
+	for {
+		key, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading bucket key: %w", err)
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("gohistogram: reading bucket count: %w", err)
+		}
+
+		sign := int(key) / (numDecades * subBucketsPerDecade)
+		rem := int(key) % (numDecades * subBucketsPerDecade)
+		decade := rem / subBucketsPerDecade
+		sub := rem % subBucketsPerDecade
+		if sign < 0 || sign >= 2 || decade < 0 || decade >= numDecades || sub < 0 || sub >= subBucketsPerDecade {
+			return nil, fmt.Errorf("gohistogram: bucket key %d out of range", key)
+		}
+		h.buckets[sign][decade][sub] = count
+	}
+
+	return h, nil
+}
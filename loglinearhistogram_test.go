@@ -0,0 +1,143 @@
+package gohistogram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogLinearHistogram(t *testing.T) {
+	h := NewLogLinearHistogram()
+	for i := 0; i < 10000; i++ {
+		h.Add(math.Abs(rand.NormFloat64()))
+	}
+
+	if h.Count() != 10000 {
+		t.Errorf("Expected h.Count() to be 10000, got %v", h.Count())
+	}
+
+	// |N(0,1)| has a median of roughly 0.675.
+	if per := h.Quantile(0.5); math.Abs(per-0.675) > 0.05 {
+		t.Errorf("Expected 50th percentile to be ~0.675, got %v", per)
+	}
+}
+
+func TestLogLinearHistogramMerge(t *testing.T) {
+	a := NewLogLinearHistogram()
+	b := NewLogLinearHistogram()
+	for i := 1; i <= 100; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i))
+	}
+
+	a.Merge(b)
+
+	if a.Count() != 200 {
+		t.Errorf("Expected a.Count() to be 200 after merge, got %v", a.Count())
+	}
+	if b.Count() != 100 {
+		t.Errorf("Expected b.Count() to be unchanged at 100, got %v", b.Count())
+	}
+}
+
+// TestLogLinearHistogramMergeConcurrentNoDeadlock guards against an
+// AB-BA deadlock: Merge must never hold both histograms' locks at once,
+// or a.Merge(b) racing b.Merge(a) can each hold the other's read lock
+// while blocked acquiring its own write lock.
+func TestLogLinearHistogramMergeConcurrentNoDeadlock(t *testing.T) {
+	a := NewLogLinearHistogram()
+	b := NewLogLinearHistogram()
+	a.Add(1)
+	b.Add(2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			a.Merge(b)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			b.Merge(a)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Merge deadlocked under concurrent bidirectional merges")
+	}
+}
+
+func TestLogLinearHistogramSerialize(t *testing.T) {
+	h := NewLogLinearHistogram()
+	for i := 0; i < 1000; i++ {
+		h.Add(rand.NormFloat64() * 10)
+	}
+
+	data := h.Serialize()
+	h2, err := DeserializeLogLinearHistogram(data)
+	if err != nil {
+		t.Fatalf("Deserialize returned error: %v", err)
+	}
+
+	if h2.Count() != h.Count() {
+		t.Errorf("Expected round-tripped Count() %v, got %v", h.Count(), h2.Count())
+	}
+	if h2.Quantile(0.5) != h.Quantile(0.5) {
+		t.Errorf("Expected round-tripped Quantile(0.5) %v, got %v", h.Quantile(0.5), h2.Quantile(0.5))
+	}
+}
+
+func TestLogLinearHistogramDeserializeRejectsCorruptKey(t *testing.T) {
+	h := NewLogLinearHistogram()
+	h.Add(1)
+	h.Add(100)
+	data := h.Serialize()
+
+	// Replace the trailing (key, count) pair with an out-of-range key. That
+	// pair is 3 bytes here: a 2-byte uvarint key and a 1-byte count.
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-3])
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], 999999999)
+	buf.Write(tmp[:n])
+	buf.WriteByte(1)
+
+	if _, err := DeserializeLogLinearHistogram(buf.Bytes()); err == nil {
+		t.Error("Expected an error for an out-of-range bucket key, got nil")
+	}
+}
+
+func TestLogLinearHistogramNegativeAndZero(t *testing.T) {
+	h := NewLogLinearHistogram()
+	h.Add(-5)
+	h.Add(0)
+	h.Add(5)
+
+	if h.Count() != 3 {
+		t.Errorf("Expected h.Count() to be 3, got %v", h.Count())
+	}
+	if h.Min() != -5 {
+		t.Errorf("Expected h.Min() to be -5, got %v", h.Min())
+	}
+	if h.Max() != 5 {
+		t.Errorf("Expected h.Max() to be 5, got %v", h.Max())
+	}
+	if cdf := h.CDF(0); cdf < 2.0/3 {
+		t.Errorf("Expected h.CDF(0) to include the zero bucket, got %v", cdf)
+	}
+}
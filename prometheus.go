@@ -0,0 +1,109 @@
+package gohistogram
+
+// Copyright (c) 2013 VividCortex, Inc. All rights reserved.
+// Please see the LICENSE file for applicable license terms.
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Histogram is the minimal read contract PrometheusExporter needs to
+// export a histogram's bins to Prometheus: the bin count and value at
+// each index, plus the cumulative distribution function. Both
+// WeightedHistogram and LogLinearHistogram satisfy it.
+type Histogram interface {
+	BinsCount() int
+	Bins(i int) (count float64, value float64)
+	CDF(x float64) float64
+}
+
+// PrometheusExporter adapts a Histogram to the Prometheus text exposition
+// format, exposing it as a cumulative histogram metric with the given
+// name, help text and constant labels. It implements prometheus.Collector
+// so it can be registered directly with a prometheus.Registry.
+//
+// The exported cumulative bucket boundaries (the "le" values) are either
+// the ones passed to NewPrometheusExporter, or, if none are given, derived
+// from the wrapped histogram's own bin values at collection time.
+type PrometheusExporter struct {
+	hist   Histogram
+	name   string
+	help   string
+	labels prometheus.Labels
+	desc   *prometheus.Desc
+	bounds []float64
+}
+
+// NewPrometheusExporter returns a PrometheusExporter wrapping hist. bounds
+// are the cumulative ("le") bucket boundaries to report; if bounds is
+// empty, the boundaries are derived from hist.Bins at each Collect call.
+func NewPrometheusExporter(hist Histogram, name, help string, labels prometheus.Labels, bounds []float64) *PrometheusExporter {
+	return &PrometheusExporter{
+		hist:   hist,
+		name:   name,
+		help:   help,
+		labels: labels,
+		desc:   prometheus.NewDesc(name, help, nil, labels),
+		bounds: bounds,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (e *PrometheusExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.desc
+}
+
+// Collect implements prometheus.Collector.
+func (e *PrometheusExporter) Collect(ch chan<- prometheus.Metric) {
+	bounds := e.bounds
+	if len(bounds) == 0 {
+		bounds = binEdges(e.hist)
+	}
+
+	buckets := make(map[float64]uint64, len(bounds))
+	floatTotal := 0.0
+	sum := 0.0
+	count := e.hist.BinsCount()
+	for i := 0; i < count; i++ {
+		c, v := e.hist.Bins(i)
+		floatTotal += c
+		sum += v * c
+	}
+	total := uint64(math.Round(floatTotal))
+
+	for _, le := range bounds {
+		buckets[le] = uint64(math.Round(floatTotal * e.hist.CDF(le)))
+	}
+
+	metric, err := prometheus.NewConstHistogram(e.desc, total, sum, buckets)
+	if err != nil {
+		log.Errorf("PrometheusExporter.Collect: %s", err)
+		return
+	}
+	ch <- metric
+}
+
+// binEdges returns the value Bins reports for each of h's bins (not a bin
+// edge; for LogLinearHistogram it is the bucket midpoint), in ascending
+// order, for use as "le" boundaries when the caller hasn't supplied
+// explicit ones.
+func binEdges(h Histogram) []float64 {
+	n := h.BinsCount()
+	edges := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		_, v := h.Bins(i)
+		edges = append(edges, v)
+	}
+	return edges
+}
+
+// Handler returns an http.Handler serving every collector registered in
+// reg at request time (such as a PrometheusExporter) in Prometheus text
+// exposition format, suitable for mounting at /metrics.
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,105 @@
+package gohistogram
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	h := NewWeightedHistogram(20, 1)
+	for i := 0; i < 100; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	reg := prometheus.NewRegistry()
+	exp := NewPrometheusExporter(h, "test_histogram", "help text", nil, []float64{-1, 0, 1})
+	reg.MustRegister(exp)
+
+	out, err := testutil.GatherAndCount(reg)
+	if err != nil {
+		t.Fatalf("GatherAndCount returned error: %v", err)
+	}
+	if out != 1 {
+		t.Errorf("Expected exactly 1 metric family, got %d", out)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+	text := families[0].String()
+	if !strings.Contains(text, `name:"test_histogram"`) {
+		t.Errorf("Expected rendered output to contain the metric name, got: %s", text)
+	}
+	if !strings.Contains(text, "sample_count:100") {
+		t.Errorf("Expected rendered output to contain the total count, got: %s", text)
+	}
+}
+
+// TestPrometheusExporterDecayedCounts guards against Collect truncating
+// each bin's (fractional, EWMA-decayed) count toward zero before summing:
+// that systematically undercounts sample_count and every le bucket for
+// any histogram actually decaying (alpha < 1), which none of the other
+// tests here catch since they all use alpha = 1.
+func TestPrometheusExporterDecayedCounts(t *testing.T) {
+	h := NewWeightedHistogram(20, 0.9) // average age of 19 samples
+	for i := 0; i < 2000; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	nodes := h.list.All()
+	wantTotal := 0.0
+	for _, n := range nodes {
+		wantTotal += n.count
+	}
+
+	reg := prometheus.NewRegistry()
+	exp := NewPrometheusExporter(h, "test_decayed_histogram", "help text", nil, []float64{-1, 0, 1})
+	reg.MustRegister(exp)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+
+	got := float64(families[0].Metric[0].GetHistogram().GetSampleCount())
+	if math.Abs(got-wantTotal) > 1 {
+		t.Errorf("Expected reported sample_count %v to be within 1 of the actual bin count sum %v (per-bin truncation before summing would undercount by far more)", got, wantTotal)
+	}
+}
+
+func TestPrometheusExporterLogLinearHistogram(t *testing.T) {
+	h := NewLogLinearHistogram()
+	for i := 0; i < 100; i++ {
+		h.Add(rand.NormFloat64())
+	}
+
+	reg := prometheus.NewRegistry()
+	exp := NewPrometheusExporter(h, "test_loglinear_histogram", "help text", nil, []float64{-1, 0, 1})
+	reg.MustRegister(exp)
+
+	out, err := testutil.GatherAndCount(reg)
+	if err != nil {
+		t.Fatalf("GatherAndCount returned error: %v", err)
+	}
+	if out != 1 {
+		t.Errorf("Expected exactly 1 metric family, got %d", out)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+	text := families[0].String()
+	if !strings.Contains(text, `name:"test_loglinear_histogram"`) {
+		t.Errorf("Expected rendered output to contain the metric name, got: %s", text)
+	}
+	if !strings.Contains(text, "sample_count:100") {
+		t.Errorf("Expected rendered output to contain the total count, got: %s", text)
+	}
+}
@@ -7,6 +7,7 @@ package gohistogram
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"sort"
 
@@ -18,13 +19,69 @@ var log = slf.WithContext("gohistogram")
 // A WeightedHistogram implements Histogram. A WeightedHistogram has bins that have values
 // which are exponentially weighted moving averages. This allows you keep inserting large
 // amounts of data into the histogram and approximate quantiles with recency factored in.
+//
+// WeightedHistogram is safe for concurrent use: Add, Merge, Clear and all read methods
+// take a mutex internally, so a single histogram can be shared by many writer goroutines,
+// e.g. for per-request latency recording.
+//
+// The zero value is a valid, empty histogram: list and gaps are allocated lazily on the
+// first Add or Merge, and every read method treats a nil list as empty. maxbins of 0 means
+// Add will trim down to a single bin, so most callers will still want NewWeightedHistogram
+// to set a useful maxbins and alpha.
+//
+// Bins are stored in a binList (a value-ordered skip list) rather than a plain slice, with
+// a gapHeap tracking the distance between adjacent bins. This keeps insertion, deletion and
+// indexed lookup (BinsCount/Bins) O(log k) in the number of bins k, instead of the O(k)
+// scan-and-shift a slice needs on every trim.
+//
+// Quantile and CDF are backed by qIndex, a cumulative-weight index rebuilt lazily the first
+// time either is called after a mutation (Add, Merge, Clear, ...), rather than maintained
+// incrementally: the EWMA decay in scaleDown already touches every bin's count on every Add,
+// so keeping the index up to date on every write would cost the same O(k) it's meant to
+// avoid. What this buys instead is O(log k) Quantile/CDF calls for the common read-heavy
+// pattern — many percentile queries between writes, e.g. a dashboard polling a histogram an
+// ingestion path updates far less often — at the cost of one O(k) rebuild on the first query
+// after each write. Mean/Variance/Modes/String still do a full O(k) pass every call.
 type WeightedHistogram struct {
-	bins    []bin
+	mu      sync.RWMutex
+	list    *binList
+	gaps    gapHeap
+	qIndex  *quantileIndex
 	maxbins int
 	total   int64
 	alpha   float64
 }
 
+// quantileIndex caches, for the bins as of the last rebuild, their values
+// and the cumulative sum of their counts in ascending value order, so
+// Quantile and CDF can binary search it instead of scanning every bin.
+type quantileIndex struct {
+	values []float64
+	cum    []float64
+}
+
+// ensureQuantileIndex rebuilds h.qIndex if it was invalidated by a write
+// since the last rebuild. Callers must hold h.mu for writing, since a
+// cache miss mutates h.qIndex even though Quantile/CDF are conceptually
+// read-only.
+func (h *WeightedHistogram) ensureQuantileIndex() {
+	if h.qIndex != nil {
+		return
+	}
+	nodes := h.list.All()
+	idx := &quantileIndex{
+		values: make([]float64, len(nodes)),
+		cum:    make([]float64, len(nodes)),
+	}
+	sum := 0.0
+	for i, n := range nodes {
+		sum += n.count
+		idx.values[i] = n.value
+		idx.cum[i] = sum
+	}
+	h.qIndex = idx
+}
+
 type histogramStateBin struct {
 	Value float64
 	Count float64
@@ -37,18 +94,21 @@ type histogramState struct {
 	Alpha   float64
 }
 
-//MarshalJSON implements json.Marshaller
+// MarshalJSON implements json.Marshaller
 func (h *WeightedHistogram) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	nodes := h.list.All()
 	hs := &histogramState{
-		Bins:    make([]histogramStateBin, len(h.bins), len(h.bins)),
+		Bins:    make([]histogramStateBin, len(nodes)),
 		MaxBins: h.maxbins,
 		Total:   h.total,
 		Alpha:   h.alpha,
 	}
-	for i, v := range h.bins {
+	for i, n := range nodes {
 		hs.Bins[i] = histogramStateBin{
-			Value: v.value,
-			Count: v.count,
+			Value: n.value,
+			Count: n.count,
 		}
 	}
 	result, err := json.Marshal(hs)
@@ -59,7 +119,7 @@ func (h *WeightedHistogram) MarshalJSON() ([]byte, error) {
 	return result, nil
 }
 
-//UnmarshalJSON implements json.Unmarshaller
+// UnmarshalJSON implements json.Unmarshaller
 func (h *WeightedHistogram) UnmarshalJSON(data []byte) error {
 	var hs histogramState
 	err := json.Unmarshal(data, &hs)
@@ -67,17 +127,18 @@ func (h *WeightedHistogram) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	h.bins = make([]bin, len(hs.Bins), len(hs.Bins))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.maxbins = hs.MaxBins
 	h.alpha = hs.Alpha
 	h.total = hs.Total
 
-	for i, v := range hs.Bins {
-		h.bins[i] = bin{
-			value: v.Value,
-			count: v.Count,
-		}
+	h.list = newBinList()
+	for _, v := range hs.Bins {
+		h.list.Insert(v.Value, v.Count)
 	}
+	h.rebuildGaps()
 
 	return nil
 }
@@ -93,7 +154,7 @@ func (h *WeightedHistogram) UnmarshalJSON(data []byte) error {
 // alpha of 0.935483870967742.
 func NewWeightedHistogram(n int, alpha float64) *WeightedHistogram {
 	return &WeightedHistogram{
-		bins:    make([]bin, 0),
+		list:    newBinList(),
 		maxbins: n,
 		total:   0,
 		alpha:   alpha,
@@ -105,64 +166,93 @@ func ewma(existingVal float64, newVal float64, alpha float64) (result float64) {
 	return
 }
 
-func (h *WeightedHistogram) scaleDown(except int) {
-	for i := range h.bins {
-		if i != except {
-			h.bins[i].count = ewma(h.bins[i].count, 0, h.alpha)
+func (h *WeightedHistogram) scaleDown(except *binNode) {
+	for _, n := range h.list.All() {
+		if n != except {
+			n.count = ewma(n.count, 0, h.alpha)
 		}
 	}
 }
 
+// pushGapsAround records the gaps to either side of node, used whenever
+// node is newly inserted or has just taken on a new value.
+func (h *WeightedHistogram) pushGapsAround(node *binNode) {
+	if pred := h.list.Predecessor(node); pred != h.list.head {
+		pushGap(&h.gaps, pred)
+	}
+	pushGap(&h.gaps, node)
+}
+
+// gapHeapSlack bounds how many stale entries trim lets accumulate in the
+// gap heap (as a multiple of maxbins) before compactGaps rebuilds it from
+// scratch. Without this, a histogram under sustained Adds would grow the
+// heap without bound, since every trim discards a gap entry but leaves
+// any other entries referencing the same nodes in place until popped.
+const gapHeapSlack = 4
+
+// compactGaps rebuilds the gap heap once it has accumulated enough stale
+// entries, so long-running histograms don't leak memory across a large
+// number of Adds. Rebuilding is O(k), but amortizes to O(1) per Add since
+// it only runs once the heap has grown to a multiple of maxbins.
+func (h *WeightedHistogram) compactGaps() {
+	if len(h.gaps) > gapHeapSlack*h.maxbins+16 {
+		h.rebuildGaps()
+	}
+}
+
 //Add adds value to histogram
 func (h *WeightedHistogram) Add(n float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	defer h.trim()
-	for i := range h.bins {
-		if h.bins[i].value == n {
-			h.bins[i].count++
-
-			defer h.scaleDown(i)
-			return
-		}
-
-		if h.bins[i].value > n {
 
-			newbin := bin{value: n, count: 1}
-			head := append(make([]bin, 0), h.bins[0:i]...)
-
-			head = append(head, newbin)
-			tail := h.bins[i:]
-			h.bins = append(head, tail...)
+	if h.list == nil {
+		h.list = newBinList()
+	}
 
-			defer h.scaleDown(i)
-			return
-		}
+	if existing := h.list.findExact(n); existing != nil {
+		existing.count++
+		defer h.scaleDown(existing)
+		return
 	}
 
-	h.bins = append(h.bins, bin{count: 1, value: n})
+	node := h.list.Insert(n, 1)
+	h.pushGapsAround(node)
+
+	defer h.scaleDown(node)
 }
 
-// Quantile implements Histogram.Quantile and returns an approximation.
+// Quantile implements Histogram.Quantile and returns an approximation, in
+// O(log k) once qIndex is built (see WeightedHistogram's doc comment).
 func (h *WeightedHistogram) Quantile(q float64) float64 {
-	count := q * float64(h.total)
-	for i := range h.bins {
-		count -= float64(h.bins[i].count)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ensureQuantileIndex()
 
-		if count <= 0 {
-			return h.bins[i].value
-		}
+	idx := h.qIndex
+	if len(idx.values) == 0 {
+		return -1
 	}
 
-	return -1
+	target := q * float64(h.total)
+	i := sort.Search(len(idx.cum), func(i int) bool { return idx.cum[i] >= target })
+	if i == len(idx.values) {
+		return -1
+	}
+	return idx.values[i]
 }
 
-// CDF returns the value of the cumulative distribution function
-// at x
+// CDF returns the value of the cumulative distribution function at x, in
+// O(log k) once qIndex is built (see WeightedHistogram's doc comment).
 func (h *WeightedHistogram) CDF(x float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ensureQuantileIndex()
+
+	idx := h.qIndex
 	count := 0.0
-	for i := range h.bins {
-		if h.bins[i].value <= x {
-			count += float64(h.bins[i].count)
-		}
+	if i := sort.Search(len(idx.values), func(i int) bool { return idx.values[i] > x }); i > 0 {
+		count = idx.cum[i-1]
 	}
 
 	return count / float64(h.total)
@@ -170,14 +260,20 @@ func (h *WeightedHistogram) CDF(x float64) float64 {
 
 // Mean returns the sample mean of the distribution
 func (h *WeightedHistogram) Mean() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.mean()
+}
+
+func (h *WeightedHistogram) mean() float64 {
 	if h.total == 0 {
 		return 0
 	}
 
 	sum := 0.0
 
-	for i := range h.bins {
-		sum += h.bins[i].value * h.bins[i].count
+	for _, n := range h.list.All() {
+		sum += n.value * n.count
 	}
 
 	return sum / float64(h.total)
@@ -185,12 +281,13 @@ func (h *WeightedHistogram) Mean() float64 {
 
 // Modes returns values for first n maximums from histogram
 func (h *WeightedHistogram) Modes(n int) []float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	result := make([]float64, 0)
 	if h.total == 0 {
 		return result
 	}
-	tmp := make([]bin, 0)
-	tmp = append(tmp, h.bins...)
+	tmp := h.list.All()
 	sort.Slice(tmp, func(i, j int) bool {
 		return tmp[i].count >= tmp[j].count
 	})
@@ -202,15 +299,17 @@ func (h *WeightedHistogram) Modes(n int) []float64 {
 
 // Variance returns the variance of the distribution
 func (h *WeightedHistogram) Variance() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	if h.total == 0 {
 		return 0
 	}
 
 	sum := 0.0
-	mean := h.Mean()
+	mean := h.mean()
 
-	for i := range h.bins {
-		sum += (h.bins[i].count * (h.bins[i].value - mean) * (h.bins[i].value - mean))
+	for _, n := range h.list.All() {
+		sum += (n.count * (n.value - mean) * (n.value - mean))
 	}
 
 	return sum / float64(h.total)
@@ -218,63 +317,143 @@ func (h *WeightedHistogram) Variance() float64 {
 
 // Count returns approximate decayed data count
 func (h *WeightedHistogram) Count() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	return h.total
 }
 
+// Merge combines the bins of other into h, using the same value-distance
+// merge trim() uses to keep h within its maxbins limit. other is left
+// unmodified. This lets many per-shard histograms (e.g. one per CPU) be
+// combined into a single view with low write contention on each shard.
+func (h *WeightedHistogram) Merge(other *WeightedHistogram) {
+	other.mu.RLock()
+	otherNodes := other.list.All()
+	otherBins := make([]histogramStateBin, len(otherNodes))
+	for i, n := range otherNodes {
+		otherBins[i] = histogramStateBin{Value: n.value, Count: n.count}
+	}
+	other.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.trim()
+
+	if h.list == nil {
+		h.list = newBinList()
+	}
+
+	for _, b := range otherBins {
+		if existing := h.list.findExact(b.Value); existing != nil {
+			existing.count += b.Count
+			continue
+		}
+		node := h.list.Insert(b.Value, b.Count)
+		h.pushGapsAround(node)
+	}
+}
+
+// Clear resets the histogram to empty without reallocating its backing
+// storage, so it can be reused across measurement windows.
+func (h *WeightedHistogram) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.list == nil {
+		h.list = newBinList()
+	} else {
+		head := h.list.head
+		for i := range head.forward {
+			head.forward[i] = nil
+			head.span[i] = 0
+		}
+		h.list.level = 1
+		h.list.length = 0
+	}
+	h.gaps = h.gaps[:0]
+	h.qIndex = nil
+	h.total = 0
+}
+
+// rebuildGaps repopulates the gap heap from scratch, in O(k). It is used
+// after bulk loads (UnmarshalJSON/UnmarshalBinary/BinaryReader), by
+// compactGaps, and as a fallback in trim if the heap is ever fully
+// drained of valid entries, which shouldn't normally happen since trim
+// always replaces what it removes. It also invalidates qIndex, which the
+// bulk-load callers rely on since they have just replaced h.list wholesale.
+func (h *WeightedHistogram) rebuildGaps() {
+	h.gaps = h.gaps[:0]
+	nodes := h.list.All()
+	for i := 0; i+1 < len(nodes); i++ {
+		pushGap(&h.gaps, nodes[i])
+	}
+	h.qIndex = nil
+}
+
 func (h *WeightedHistogram) trim() {
 	total := 0.0
-	for i := range h.bins {
-		total += h.bins[i].count
+	for _, n := range h.list.All() {
+		total += n.count
 	}
 	h.total = int64(total)
-	for len(h.bins) > h.maxbins {
-
-		// Find closest bins in terms of value
-		minDelta := 1e99
-		minDeltaIndex := 0
-		for i := range h.bins {
-			if i == 0 {
-				continue
-			}
 
-			if delta := h.bins[i].value - h.bins[i-1].value; delta < minDelta {
-				minDelta = delta
-				minDeltaIndex = i
+	for h.list.Len() > h.maxbins {
+		entry := popValidGap(&h.gaps)
+		if entry == nil {
+			h.rebuildGaps()
+			entry = popValidGap(&h.gaps)
+			if entry == nil {
+				break
 			}
 		}
 
-		// We need to merge bins minDeltaIndex-1 and minDeltaIndex
-		b1 := h.bins[minDeltaIndex-1]
-		b2 := h.bins[minDeltaIndex]
-		totalCount := b1.count + b2.count
+		left := entry.left
+		right := left.forward[0]
+		pred := h.list.Predecessor(left)
+
+		totalCount := left.count + right.count
 		var newValue float64
 		if totalCount <= 1 {
-			newValue = (b1.value + b2.value) / 2
+			newValue = (left.value + right.value) / 2
 		} else {
-			newValue = (b1.value*b1.count + b2.value*b2.count) / totalCount // weighted average
+			newValue = (left.value*left.count + right.value*right.count) / totalCount // weighted average
 		}
-		//log.Debugf("trim: %d %d %f %f", len(h.bins), minDeltaIndex, newValue, totalCount)
-		mergedbin := bin{
-			value: newValue,
-			count: totalCount, // summed heights
+
+		h.list.Delete(right)
+		left.value = newValue
+		left.count = totalCount
+		left.gen++
+		// left's own gap entries are invalidated by the gen bump above and
+		// by Delete's bump of left.gen when right was removed, but left's
+		// predecessor may hold a cached entry for the (pred, left) gap
+		// whose delta was computed from left's old value. left.gen didn't
+		// change from pred's point of view (pred's entry keys off pred's
+		// own gen), so bump it explicitly or that stale, too-small delta
+		// would sit in the heap forever and get picked over the true
+		// closest pair.
+		if pred != h.list.head {
+			pred.gen++
 		}
-		head := append(make([]bin, 0), h.bins[0:minDeltaIndex-1]...)
-		tail := append([]bin{mergedbin}, h.bins[minDeltaIndex+1:]...)
-		h.bins = append(head, tail...)
+
+		h.pushGapsAround(left)
 	}
+
+	h.compactGaps()
+	h.qIndex = nil
 }
 
 // String returns a string reprentation of the histogram,
 // which is useful for printing to a terminal.
 func (h *WeightedHistogram) String() (str string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	str += fmt.Sprintln("Total:", h.total)
 
-	for i := range h.bins {
+	for _, n := range h.list.All() {
 		var bar string
-		for j := 0; j < int(float64(h.bins[i].count)/float64(h.total)*200); j++ {
+		for j := 0; j < int(n.count/float64(h.total)*200); j++ {
 			bar += "."
 		}
-		str += fmt.Sprintln(h.bins[i].value, "\t", bar)
+		str += fmt.Sprintln(n.value, "\t", bar)
 	}
 
 	return
@@ -282,19 +461,25 @@ func (h *WeightedHistogram) String() (str string) {
 
 //BinsCount implements Histogram
 func (h *WeightedHistogram) BinsCount() int {
-	return len(h.bins)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.list.Len()
 }
 
 //Bins implements Histogram
 func (h *WeightedHistogram) Bins(i int) (float64, float64) {
-	if i < 0 || i >= len(h.bins) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	node := h.list.Get(i)
+	if node == nil {
 		return 0, 0
 	}
-	b := h.bins[i]
-	return b.count, b.value
+	return node.count, node.value
 }
 
 //Alpha returns decay factor
 func (h *WeightedHistogram) Alpha() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	return h.alpha
 }
@@ -0,0 +1,150 @@
+package gohistogram
+
+// Copyright (c) 2013 VividCortex, Inc. All rights reserved.
+// Please see the LICENSE file for applicable license terms.
+
+import (
+	"sync"
+	"time"
+)
+
+// A WindowedHistogram maintains a ring of numWindows WeightedHistograms,
+// each covering one windowDuration-sized slice of time, and advances to
+// the next slot on a ticker, clearing the oldest slot as it goes. Unlike
+// WeightedHistogram's EWMA decay, which forgets old data gradually and
+// implicitly, a WindowedHistogram can answer "what was the p99 over the
+// last window" and "over the window before that" directly, and fully
+// drops data older than numWindows*windowDuration.
+//
+// The zero value is not ready to use; call NewWindowedHistogram.
+type WindowedHistogram struct {
+	mu      sync.RWMutex
+	windows []*WeightedHistogram
+	current int
+
+	maxbins int
+	alpha   float64
+
+	ticker *time.Ticker
+	stop   chan struct{}
+}
+
+// NewWindowedHistogram returns a WindowedHistogram with numWindows
+// sub-histograms, each covering windowDuration, advancing automatically
+// on a ticker. Each sub-histogram is a WeightedHistogram(maxbins, alpha).
+// Call Stop when the WindowedHistogram is no longer needed to release the
+// background ticker goroutine.
+//
+// NewWindowedHistogram panics if numWindows is less than 1, since a
+// zero-length ring has no window for Add to land in.
+func NewWindowedHistogram(numWindows int, windowDuration time.Duration, maxbins int, alpha float64) *WindowedHistogram {
+	if numWindows < 1 {
+		panic("gohistogram: NewWindowedHistogram: numWindows must be at least 1")
+	}
+
+	w := &WindowedHistogram{
+		windows: make([]*WeightedHistogram, numWindows),
+		maxbins: maxbins,
+		alpha:   alpha,
+		ticker:  time.NewTicker(windowDuration),
+		stop:    make(chan struct{}),
+	}
+	for i := range w.windows {
+		w.windows[i] = NewWeightedHistogram(maxbins, alpha)
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *WindowedHistogram) run() {
+	for {
+		select {
+		case <-w.ticker.C:
+			w.advance()
+		case <-w.stop:
+			w.ticker.Stop()
+			return
+		}
+	}
+}
+
+func (w *WindowedHistogram) advance() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current = (w.current + 1) % len(w.windows)
+	w.windows[w.current].Clear()
+}
+
+// Stop halts the background ticker. A stopped WindowedHistogram still
+// accepts Add calls, into whatever window was active when it stopped, and
+// still answers queries across all its sub-windows; it just no longer
+// rotates.
+func (w *WindowedHistogram) Stop() {
+	close(w.stop)
+}
+
+// Add adds value to the currently active sub-window.
+func (w *WindowedHistogram) Add(value float64) {
+	w.mu.RLock()
+	cur := w.windows[w.current]
+	w.mu.RUnlock()
+	cur.Add(value)
+}
+
+// merged returns a fresh WeightedHistogram combining every live
+// sub-window via Merge, representing the full rolling window.
+func (w *WindowedHistogram) merged() *WeightedHistogram {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	merged := NewWeightedHistogram(w.maxbins, w.alpha)
+	for _, win := range w.windows {
+		merged.Merge(win)
+	}
+	return merged
+}
+
+// Quantile returns an approximation of the q'th quantile across the full
+// rolling window.
+func (w *WindowedHistogram) Quantile(q float64) float64 {
+	return w.merged().Quantile(q)
+}
+
+// CDF returns the value of the cumulative distribution function at x
+// across the full rolling window.
+func (w *WindowedHistogram) CDF(x float64) float64 {
+	return w.merged().CDF(x)
+}
+
+// Mean returns the sample mean across the full rolling window.
+func (w *WindowedHistogram) Mean() float64 {
+	return w.merged().Mean()
+}
+
+// Count returns the approximate decayed data count across the full
+// rolling window.
+func (w *WindowedHistogram) Count() int64 {
+	return w.merged().Count()
+}
+
+// NumWindows returns the number of sub-windows this histogram rotates
+// through.
+func (w *WindowedHistogram) NumWindows() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.windows)
+}
+
+// Window returns the i'th most recent sub-window: Window(0) is the
+// currently active window, Window(1) is the one before it, and so on up
+// to NumWindows()-1. The returned WeightedHistogram is shared with the
+// WindowedHistogram and must not be mutated by callers.
+func (w *WindowedHistogram) Window(i int) *WeightedHistogram {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	n := len(w.windows)
+	idx := ((w.current-i)%n + n) % n
+	return w.windows[idx]
+}
@@ -0,0 +1,62 @@
+package gohistogram
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWindowedHistogram(t *testing.T) {
+	w := NewWindowedHistogram(3, 20*time.Millisecond, 20, 1)
+	defer w.Stop()
+
+	for i := 0; i < 50; i++ {
+		w.Add(rand.NormFloat64())
+	}
+	if w.Count() != 50 {
+		t.Errorf("Expected w.Count() to be 50, got %v", w.Count())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		w.Add(rand.NormFloat64())
+	}
+
+	if w.Count() != 70 {
+		t.Errorf("Expected w.Count() to be 70 after advancing, got %v", w.Count())
+	}
+	if w.Window(0).Count() != 20 {
+		t.Errorf("Expected current window Count() to be 20, got %v", w.Window(0).Count())
+	}
+	if w.Window(1).Count() != 50 {
+		t.Errorf("Expected previous window Count() to be 50, got %v", w.Window(1).Count())
+	}
+}
+
+func TestWindowedHistogramEvictsOldData(t *testing.T) {
+	w := NewWindowedHistogram(3, 20*time.Millisecond, 20, 1)
+	defer w.Stop()
+
+	for i := 0; i < 50; i++ {
+		w.Add(rand.NormFloat64())
+	}
+
+	time.Sleep(80 * time.Millisecond)
+
+	if w.Count() > 0 {
+		t.Errorf("Expected data older than the window to be evicted, got Count() %v", w.Count())
+	}
+}
+
+func TestNewWindowedHistogramRejectsNonPositiveNumWindows(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected NewWindowedHistogram(%d, ...) to panic", n)
+				}
+			}()
+			NewWindowedHistogram(n, 20*time.Millisecond, 20, 1)
+		}()
+	}
+}